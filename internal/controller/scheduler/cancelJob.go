@@ -0,0 +1,45 @@
+package scheduler
+
+import (
+	"context"
+
+	"github.com/Khan/genqlient/graphql"
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// cancelCommandJob queries the Buildkite command job identified by jobUUID
+// and, unless it's already Canceled/Canceling, cancels it. clientMutationID
+// is attached to the mutation so the reason shows up in the Buildkite UI
+// instead of a silent cancel. It's shared by the pod failure and node loss
+// watchers, which both end up needing to turn "this pod's job should stop"
+// into the same GraphQL round trip.
+func cancelCommandJob(ctx context.Context, gql graphql.Client, log *zap.Logger, jobUUID uuid.UUID, clientMutationID string) {
+	resp, err := api.GetCommandJob(ctx, gql, jobUUID.String())
+	if err != nil {
+		log.Warn("Failed to query command job", zap.Error(err))
+		return
+	}
+
+	job, isCommandJob := resp.GetJob().(*api.GetCommandJobJobJobTypeCommand)
+	if !isCommandJob {
+		log.Warn("Job was not a command job")
+		return
+	}
+
+	// This is expected as there will be a gap between when a cancel request
+	// completes and the Kubernetes job is cleaned up, during which more
+	// events for the same pod (or more pods destined to fail the same way)
+	// may still come in.
+	if job.GetState() == api.JobStatesCanceled || job.GetState() == api.JobStatesCanceling {
+		return
+	}
+
+	if _, err := api.CancelCommandJob(ctx, gql, api.JobTypeCommandCancelInput{
+		ClientMutationId: clientMutationID,
+		Id:               job.GetId(),
+	}); err != nil {
+		log.Warn("Failed to cancel command job", zap.Error(err), zap.String("state", string(job.GetState())))
+	}
+}
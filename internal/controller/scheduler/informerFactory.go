@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+)
+
+// minServerVersionMajor/Minor is the oldest Kubernetes server version the
+// namespace+label-selector scoped informer factory below, and the Events
+// informer the pod failure watcher relies on, are known to work against.
+const (
+	minServerVersionMajor = 1
+	minServerVersionMinor = 19
+)
+
+const defaultInformerResyncPeriod = 30 * time.Second
+
+// NewScopedPodInformerFactory builds the two SharedInformerFactories used by
+// the pod failure and node loss watchers.
+//
+// podFactory is scoped to cfg.PodNamespace and cfg.PodLabelSelector, rather
+// than watching every pod in the cluster. This matters when agent-stack-k8s
+// is deployed alongside other Buildkite-managed workloads, or shared by
+// multiple tenants, where reacting to every pod's UUID label would be too
+// broad.
+//
+// baseFactory is scoped to cfg.PodNamespace only, with no label selector. It
+// backs the Nodes and Events informers the node loss and pod failure
+// watchers also register: neither Nodes nor Events carry the Buildkite job
+// UUID label, so building them from podFactory would apply
+// cfg.PodLabelSelector to them too and, as soon as that selector is set,
+// silently starve both informers (informers.WithTweakListOptions is a
+// factory-wide hook, not a per-resource one).
+//
+// It refuses to build either factory if the connected cluster's server
+// version predates the informer options they rely on.
+func NewScopedPodInformerFactory(
+	ctx context.Context,
+	k8s kubernetes.Interface,
+	cfg *config.Config,
+) (podFactory, baseFactory informers.SharedInformerFactory, err error) {
+	if err := validateServerVersion(k8s.Discovery()); err != nil {
+		return nil, nil, err
+	}
+
+	baseOpts := []informers.SharedInformerOption{}
+	if cfg.PodNamespace != "" {
+		baseOpts = append(baseOpts, informers.WithNamespace(cfg.PodNamespace))
+	}
+	baseFactory = informers.NewSharedInformerFactoryWithOptions(k8s, defaultInformerResyncPeriod, baseOpts...)
+
+	podOpts := []informers.SharedInformerOption{}
+	if cfg.PodNamespace != "" {
+		podOpts = append(podOpts, informers.WithNamespace(cfg.PodNamespace))
+	}
+	if cfg.PodLabelSelector != "" {
+		selector := cfg.PodLabelSelector
+		podOpts = append(podOpts, informers.WithTweakListOptions(func(lo *metav1.ListOptions) {
+			lo.LabelSelector = selector
+		}))
+	}
+	podFactory = informers.NewSharedInformerFactoryWithOptions(k8s, defaultInformerResyncPeriod, podOpts...)
+
+	return podFactory, baseFactory, nil
+}
+
+// validateServerVersion refuses to start the pod failure / node loss
+// watchers against a Kubernetes API server too old to reliably support the
+// namespace and label-selector scoped informers they're built on.
+func validateServerVersion(disco discovery.DiscoveryInterface) error {
+	sv, err := disco.ServerVersion()
+	if err != nil {
+		return fmt.Errorf("couldn't determine Kubernetes server version: %w", err)
+	}
+
+	major, err := strconv.Atoi(trimVersionSuffix(sv.Major))
+	if err != nil {
+		return fmt.Errorf("couldn't parse Kubernetes server major version %q: %w", sv.Major, err)
+	}
+	minor, err := strconv.Atoi(trimVersionSuffix(sv.Minor))
+	if err != nil {
+		return fmt.Errorf("couldn't parse Kubernetes server minor version %q: %w", sv.Minor, err)
+	}
+
+	if major < minServerVersionMajor || (major == minServerVersionMajor && minor < minServerVersionMinor) {
+		return fmt.Errorf(
+			"Kubernetes server version %s.%s is too old: pod failure and node loss watching requires at least %d.%d",
+			sv.Major, sv.Minor, minServerVersionMajor, minServerVersionMinor,
+		)
+	}
+	return nil
+}
+
+// trimVersionSuffix strips the trailing "+" that EKS/GKE/etc. append to
+// version components (e.g. "19+") so it can be parsed as a plain integer.
+func trimVersionSuffix(s string) string {
+	return strings.TrimSuffix(s, "+")
+}
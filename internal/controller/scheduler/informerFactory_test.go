@@ -0,0 +1,79 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+)
+
+// fakeDiscovery implements discovery.DiscoveryInterface, reporting a fixed
+// ServerVersion (or error) for validateServerVersion to inspect.
+type fakeDiscovery struct {
+	discovery.DiscoveryInterface
+	version *version.Info
+	err     error
+}
+
+func (f *fakeDiscovery) ServerVersion() (*version.Info, error) {
+	return f.version, f.err
+}
+
+func TestValidateServerVersion(t *testing.T) {
+	cases := []struct {
+		name    string
+		version *version.Info
+		err     error
+		wantErr bool
+	}{
+		{
+			name:    "at minimum version",
+			version: &version.Info{Major: "1", Minor: "19"},
+			wantErr: false,
+		},
+		{
+			name:    "newer than minimum",
+			version: &version.Info{Major: "1", Minor: "28"},
+			wantErr: false,
+		},
+		{
+			name:    "newer major version",
+			version: &version.Info{Major: "2", Minor: "0"},
+			wantErr: false,
+		},
+		{
+			name:    "too old",
+			version: &version.Info{Major: "1", Minor: "18"},
+			wantErr: true,
+		},
+		{
+			name:    "EKS-style '+' suffix on minor version",
+			version: &version.Info{Major: "1", Minor: "19+"},
+			wantErr: false,
+		},
+		{
+			name:    "couldn't reach the server",
+			err:     errors.New("connection refused"),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateServerVersion(&fakeDiscovery{version: tc.version, err: tc.err})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("validateServerVersion() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTrimVersionSuffix(t *testing.T) {
+	if got := trimVersionSuffix("19+"); got != "19" {
+		t.Errorf("trimVersionSuffix(%q) = %q, want %q", "19+", got, "19")
+	}
+	if got := trimVersionSuffix("19"); got != "19" {
+		t.Errorf("trimVersionSuffix(%q) = %q, want %q", "19", got, "19")
+	}
+}
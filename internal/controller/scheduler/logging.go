@@ -0,0 +1,34 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultEventHandlerTimeout bounds how long a single informer event handler
+// (and any Buildkite GraphQL calls it makes) is allowed to run for, derived
+// from the watcher's root context.
+const defaultEventHandlerTimeout = 30 * time.Second
+
+type loggerContextKey struct{}
+
+// ContextWithLogger returns a copy of ctx carrying log, retrievable with
+// LoggerFromContext. The top-level controller attaches a logger scoped with
+// shared request/trace fields to its root context; watchers in this package
+// derive their per-event context from that root so those fields make it
+// into every log entry, rather than each watcher holding a logger fixed at
+// construction time.
+func ContextWithLogger(ctx context.Context, log *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, log)
+}
+
+// LoggerFromContext returns the *zap.Logger attached to ctx by
+// ContextWithLogger, or fallback if ctx carries none.
+func LoggerFromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if log, ok := ctx.Value(loggerContextKey{}).(*zap.Logger); ok {
+		return log
+	}
+	return fallback
+}
@@ -0,0 +1,26 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestLoggerFromContext(t *testing.T) {
+	fallback := zap.NewNop()
+
+	t.Run("no logger attached", func(t *testing.T) {
+		if got := LoggerFromContext(context.Background(), fallback); got != fallback {
+			t.Errorf("LoggerFromContext() = %p, want fallback %p", got, fallback)
+		}
+	})
+
+	t.Run("logger attached", func(t *testing.T) {
+		log := zap.NewExample()
+		ctx := ContextWithLogger(context.Background(), log)
+		if got := LoggerFromContext(ctx, fallback); got != log {
+			t.Errorf("LoggerFromContext() = %p, want attached logger %p", got, log)
+		}
+	})
+}
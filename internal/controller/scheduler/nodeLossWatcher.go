@@ -0,0 +1,280 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Khan/genqlient/graphql"
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// podLossStatusReasons are the values Kubernetes sets on pod.Status.Reason
+// when a pod is removed as a side effect of its node disappearing or
+// evicting it, rather than anything about the job itself.
+var podLossStatusReasons = []string{
+	"NodeLost",
+	"Evicted",
+	"Preempted",
+	"TerminationByKubelet",
+}
+
+// nodeLossWatcher watches Nodes alongside Pods, so that when a job's pod is
+// lost because the underlying node went away (rather than the job failing on
+// its own terms), the job is cancelled (or left to retry) with a clear
+// reason instead of hanging until the agent's lost-timeout.
+type nodeLossWatcher struct {
+	logger *zap.Logger
+	k8s    kubernetes.Interface
+	gql    graphql.Client
+
+	// ctx is the root context passed to RegisterInformer. Event handlers
+	// derive their per-event context from it (with a timeout) rather than
+	// using context.Background(), so that shutdown cancellation reaches
+	// in-flight GraphQL calls and any logger attached to ctx is picked up
+	// via LoggerFromContext.
+	ctx context.Context
+
+	// pods is used to find the pods scheduled to a node once it's
+	// detected as lost.
+	pods listersv1.PodLister
+
+	// detectionWindow is how long a node must be NotReady before we treat
+	// its pods as lost.
+	detectionWindow time.Duration
+	// cancelOnNodeLoss controls whether we cancel the Buildkite job
+	// (true) or merely log and let the agent's own retry/lost-timeout
+	// behaviour take over (false).
+	cancelOnNodeLoss bool
+
+	// handledJobUUIDsMu guards handledJobUUIDs.
+	handledJobUUIDsMu sync.Mutex
+	// handledJobUUIDs records job UUIDs we've already decided what to do
+	// about. A lost node, unlike a lost pod, isn't cleaned up on any bounded
+	// timescale: without this gate, every periodic informer resync would
+	// re-list the node's pods and re-query Buildkite for each one, for as
+	// long as the node lingers.
+	handledJobUUIDs map[uuid.UUID]struct{}
+}
+
+// NewNodeLossWatcher creates an informer that cancels Buildkite jobs whose
+// pods disappear because their node was lost (NotReady past the configured
+// threshold, a NodeLost deletion, or an Evicted/Preempted/TerminationByKubelet
+// eviction).
+func NewNodeLossWatcher(
+	logger *zap.Logger,
+	k8s kubernetes.Interface,
+	cfg *config.Config,
+) *nodeLossWatcher {
+	return &nodeLossWatcher{
+		logger:           logger,
+		k8s:              k8s,
+		gql:              api.NewClient(cfg.BuildkiteToken),
+		detectionWindow:  cfg.NodeLossDetectionWindow,
+		cancelOnNodeLoss: cfg.CancelJobsOnNodeLoss,
+		ctx:              context.Background(),
+		handledJobUUIDs:  make(map[uuid.UUID]struct{}),
+	}
+}
+
+// Creates Nodes and Pods informers and registers the handlers on both.
+// podFactory is the (possibly label-selector scoped) factory Pods are built
+// from; baseFactory has no label selector and is used for Nodes, which don't carry
+// the Buildkite job UUID label the Pods informer may be scoped to.
+func (w *nodeLossWatcher) RegisterInformer(
+	ctx context.Context,
+	podFactory informers.SharedInformerFactory,
+	baseFactory informers.SharedInformerFactory,
+) error {
+	w.ctx = ctx
+
+	nodeInformer := baseFactory.Core().V1().Nodes().Informer()
+	if _, err := nodeInformer.AddEventHandler(w); err != nil {
+		return err
+	}
+
+	pods := podFactory.Core().V1().Pods()
+	w.pods = pods.Lister()
+	podInformer := pods.Informer()
+	if _, err := podInformer.AddEventHandler(&podLossHandler{watcher: w}); err != nil {
+		return err
+	}
+
+	go podFactory.Start(ctx.Done())
+	go baseFactory.Start(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), nodeInformer.HasSynced, podInformer.HasSynced) {
+		return fmt.Errorf("nodeLossWatcher: timed out waiting for node and pod caches to sync")
+	}
+	return nil
+}
+
+func (w *nodeLossWatcher) OnAdd(obj any, isInInitialList bool) {}
+
+func (w *nodeLossWatcher) OnUpdate(_, maybeNode any) {
+	node, wasNode := maybeNode.(*v1.Node)
+	if !wasNode || !isNodeLost(node, w.detectionWindow) {
+		return
+	}
+	why := "not ready"
+	if node.GetDeletionTimestamp() != nil {
+		why = "terminated"
+	}
+	w.handleLostNode(node, why)
+}
+
+func (w *nodeLossWatcher) OnDelete(maybeNode any) {
+	node, wasNode := maybeNode.(*v1.Node)
+	if !wasNode {
+		return
+	}
+	w.handleLostNode(node, "terminated")
+}
+
+// handleLostNode finds the pods currently scheduled to node and cancels (or,
+// if cancelOnNodeLoss is false, just logs) the Buildkite job behind each one
+// that carries our job UUID label. why is a short human-readable description
+// of how the node was determined to be lost (e.g. "not ready", "terminated").
+func (w *nodeLossWatcher) handleLostNode(node *v1.Node, why string) {
+	log := LoggerFromContext(w.ctx, w.logger).With(zap.String("node", node.GetName()))
+	log.Info("Node lost, looking for pods to cancel", zap.String("reason", why))
+
+	pods, err := w.pods.List(labels.Everything())
+	if err != nil {
+		log.Warn("Failed to list pods to check against lost node", zap.Error(err))
+		return
+	}
+
+	reason := fmt.Sprintf("pod lost: node %s %s", node.GetName(), why)
+	ctx, cancel := context.WithTimeout(w.ctx, defaultEventHandlerTimeout)
+	defer cancel()
+	for _, pod := range pods {
+		if pod.Spec.NodeName != node.GetName() {
+			continue
+		}
+		podLog := log.With(zap.String("namespace", pod.Namespace), zap.String("podName", pod.Name))
+		w.cancelJobForPod(ctx, podLog, pod, reason)
+	}
+}
+
+// isNodeLost reports whether node has been NotReady for longer than window,
+// or is being deleted (has a DeletionTimestamp).
+func isNodeLost(node *v1.Node, window time.Duration) bool {
+	if node.GetDeletionTimestamp() != nil {
+		return true
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type != v1.NodeReady {
+			continue
+		}
+		if cond.Status == v1.ConditionTrue {
+			return false
+		}
+		return time.Since(cond.LastTransitionTime.Time) >= window
+	}
+	return false
+}
+
+// podLossHandler watches Pods for deletion or eviction caused by their node
+// disappearing, and cancels (or, if cancelOnNodeLoss is false, just logs)
+// the associated Buildkite job.
+type podLossHandler struct {
+	watcher *nodeLossWatcher
+}
+
+func (h *podLossHandler) OnAdd(obj any, isInInitialList bool) {}
+func (h *podLossHandler) OnUpdate(_, _ any)                   {}
+
+func (h *podLossHandler) OnDelete(maybePod any) {
+	pod, wasPod := maybePod.(*v1.Pod)
+	if !wasPod {
+		return
+	}
+	ctx, cancel := context.WithTimeout(h.watcher.ctx, defaultEventHandlerTimeout)
+	defer cancel()
+	h.handlePodLoss(ctx, pod)
+}
+
+func (h *podLossHandler) handlePodLoss(ctx context.Context, pod *v1.Pod) {
+	w := h.watcher
+	log := LoggerFromContext(ctx, w.logger).With(zap.String("namespace", pod.Namespace), zap.String("podName", pod.Name))
+
+	if pod.Spec.NodeName == "" {
+		// Never scheduled, so can't have been lost to a node.
+		return
+	}
+
+	reason, lost := podLossReason(pod)
+	if !lost {
+		return
+	}
+
+	w.cancelJobForPod(ctx, log.With(zap.String("node", pod.Spec.NodeName)), pod, fmt.Sprintf("pod lost: %s", reason))
+}
+
+// cancelJobForPod cancels the Buildkite command job for pod (identified by
+// our job UUID label), using reason as the cancellation reason. If
+// cancelOnNodeLoss is false, it logs and leaves the job for the agent's own
+// retry/lost-timeout behaviour instead. A job UUID is only ever acted on
+// once per nodeLossWatcher lifetime (see markHandled), so a node that stays
+// NotReady across many informer resyncs doesn't repeatedly query Buildkite
+// for the same pods.
+func (w *nodeLossWatcher) cancelJobForPod(ctx context.Context, log *zap.Logger, pod *v1.Pod, reason string) {
+	rawJobUUID, exists := pod.GetLabels()[config.UUIDLabel]
+	if !exists {
+		return
+	}
+	jobUUID, err := uuid.Parse(rawJobUUID)
+	if err != nil {
+		log.Warn("Job UUID label was not a UUID!", zap.String("jobUUID", rawJobUUID))
+		return
+	}
+	log = log.With(zap.String("jobUUID", jobUUID.String()))
+
+	if !w.markHandled(jobUUID) {
+		return
+	}
+
+	if !w.cancelOnNodeLoss {
+		log.Info("Pod lost due to node loss, but cancellation on node loss is disabled", zap.String("reason", reason))
+		return
+	}
+
+	log.Info("Pod lost due to node loss. Cancelling job.", zap.String("reason", reason))
+	cancelCommandJob(ctx, w.gql, log, jobUUID, reason)
+}
+
+// markHandled reports whether jobUUID hasn't already been handled by this
+// watcher, recording it as handled either way. It's the local gate that
+// keeps a lingering lost node — unlike a lost pod, not cleaned up on any
+// bounded timescale — from triggering a fresh round of GraphQL calls on
+// every defaultInformerResyncPeriod tick for as long as it stays NotReady.
+func (w *nodeLossWatcher) markHandled(jobUUID uuid.UUID) bool {
+	w.handledJobUUIDsMu.Lock()
+	defer w.handledJobUUIDsMu.Unlock()
+	if _, handled := w.handledJobUUIDs[jobUUID]; handled {
+		return false
+	}
+	w.handledJobUUIDs[jobUUID] = struct{}{}
+	return true
+}
+
+// podLossReason reports whether pod was removed because its node went away
+// or evicted it, and if so, the Kubernetes-assigned reason.
+func podLossReason(pod *v1.Pod) (string, bool) {
+	if slices.Contains(podLossStatusReasons, pod.Status.Reason) {
+		return pod.Status.Reason, true
+	}
+	return "", false
+}
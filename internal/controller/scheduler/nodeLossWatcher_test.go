@@ -0,0 +1,125 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsNodeLost(t *testing.T) {
+	const window = 2 * time.Minute
+
+	cases := []struct {
+		name string
+		node v1.Node
+		want bool
+	}{
+		{
+			name: "ready",
+			node: v1.Node{Status: v1.NodeStatus{Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionTrue},
+			}}},
+			want: false,
+		},
+		{
+			name: "not ready, recently",
+			node: v1.Node{Status: v1.NodeStatus{Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionFalse, LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Second))},
+			}}},
+			want: false,
+		},
+		{
+			name: "not ready, past the window",
+			node: v1.Node{Status: v1.NodeStatus{Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionFalse, LastTransitionTime: metav1.NewTime(time.Now().Add(-window * 2))},
+			}}},
+			want: true,
+		},
+		{
+			name: "no ready condition reported",
+			node: v1.Node{},
+			want: false,
+		},
+		{
+			name: "being deleted",
+			node: v1.Node{ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &metav1.Time{Time: time.Now()}}},
+			want: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isNodeLost(&tc.node, window); got != tc.want {
+				t.Errorf("isNodeLost() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNodeLossWatcherMarkHandled(t *testing.T) {
+	w := &nodeLossWatcher{handledJobUUIDs: make(map[uuid.UUID]struct{})}
+	jobUUID := uuid.New()
+
+	if !w.markHandled(jobUUID) {
+		t.Fatal("markHandled() = false on first call, want true")
+	}
+	if w.markHandled(jobUUID) {
+		t.Fatal("markHandled() = true on second call for the same UUID, want false")
+	}
+	if !w.markHandled(uuid.New()) {
+		t.Fatal("markHandled() = false for a different UUID, want true")
+	}
+}
+
+func TestPodLossReason(t *testing.T) {
+	cases := []struct {
+		name       string
+		pod        v1.Pod
+		wantLost   bool
+		wantReason string
+	}{
+		{
+			name:     "running normally",
+			pod:      v1.Pod{Status: v1.PodStatus{Phase: v1.PodRunning}},
+			wantLost: false,
+		},
+		{
+			name:       "node lost",
+			pod:        v1.Pod{Status: v1.PodStatus{Reason: "NodeLost"}},
+			wantLost:   true,
+			wantReason: "NodeLost",
+		},
+		{
+			name:       "evicted",
+			pod:        v1.Pod{Status: v1.PodStatus{Reason: "Evicted"}},
+			wantLost:   true,
+			wantReason: "Evicted",
+		},
+		{
+			name:       "preempted",
+			pod:        v1.Pod{Status: v1.PodStatus{Reason: "Preempted"}},
+			wantLost:   true,
+			wantReason: "Preempted",
+		},
+		{
+			name:     "some other unrelated status reason",
+			pod:      v1.Pod{Status: v1.PodStatus{Reason: "SomethingElse"}},
+			wantLost: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reason, lost := podLossReason(&tc.pod)
+			if lost != tc.wantLost {
+				t.Fatalf("podLossReason() lost = %v, want %v", lost, tc.wantLost)
+			}
+			if lost && reason != tc.wantReason {
+				t.Errorf("podLossReason() reason = %q, want %q", reason, tc.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Khan/genqlient/graphql"
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// unrecoverableWaitingReasons are container waiting reasons that will never
+// resolve on their own: the image cannot be pulled, or the container runtime
+// refuses to create the container at all. Jobs stuck in one of these states
+// are cancelled rather than left to the agent's lost-timeout.
+var unrecoverableWaitingReasons = []string{
+	"ErrImagePull",
+	"ImagePullBackOff",
+	"ErrImageNeverPull",
+	"InvalidImageName",
+	"CreateContainerConfigError",
+	"CreateContainerError",
+}
+
+// crashLoopBackOffThreshold is the restart count at which a system container
+// in CrashLoopBackOff is treated as unrecoverable.
+const crashLoopBackOffThreshold = 3
+
+// podFailureWatcher watches Pods and Pod Events for container states that
+// will never resolve on their own (bad image references, container config
+// errors, persistent crash looping) and cancels the corresponding Buildkite
+// job, rather than leaving it to hang until the agent's lost-timeout.
+type podFailureWatcher struct {
+	logger *zap.Logger
+	k8s    kubernetes.Interface
+	gql    graphql.Client
+
+	// ctx is the root context passed to RegisterInformer. Event handlers
+	// derive their per-event context from it (with a timeout) rather than
+	// using context.Background(), so that shutdown cancellation reaches
+	// in-flight GraphQL calls and any logger attached to ctx by the
+	// top-level controller is picked up via LoggerFromContext.
+	ctx context.Context
+
+	// podFailureWatcher waits at least this duration after pod creation
+	// before it cancels the job.
+	gracePeriod time.Duration
+}
+
+// NewPodFailureWatcher creates an informer that will use the Buildkite
+// GraphQL API to cancel jobs whose pods have entered an unrecoverable state,
+// such as ImagePullBackOff, a container config error, or a persistent
+// CrashLoopBackOff.
+func NewPodFailureWatcher(
+	logger *zap.Logger,
+	k8s kubernetes.Interface,
+	cfg *config.Config,
+) *podFailureWatcher {
+	return &podFailureWatcher{
+		logger:      logger,
+		k8s:         k8s,
+		gql:         api.NewClient(cfg.BuildkiteToken),
+		gracePeriod: cfg.ImagePullBackOffGradePeriod,
+		ctx:         context.Background(),
+	}
+}
+
+// Creates Pods and Events informers and registers the handlers on both.
+// podFactory is the (possibly label-selector scoped) factory Pods are built
+// from; baseFactory has no label selector and is used for Events, which don't carry
+// the Buildkite job UUID label the Pods informer may be scoped to.
+func (w *podFailureWatcher) RegisterInformer(
+	ctx context.Context,
+	podFactory informers.SharedInformerFactory,
+	baseFactory informers.SharedInformerFactory,
+) error {
+	w.ctx = ctx
+
+	pods := podFactory.Core().V1().Pods()
+	podInformer := pods.Informer()
+	if _, err := podInformer.AddEventHandler(w); err != nil {
+		return err
+	}
+
+	eventInformer := baseFactory.Core().V1().Events().Informer()
+	if _, err := eventInformer.AddEventHandler(&podEventHandler{watcher: w, pods: pods.Lister()}); err != nil {
+		return err
+	}
+
+	go podFactory.Start(ctx.Done())
+	go baseFactory.Start(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced, eventInformer.HasSynced) {
+		return fmt.Errorf("podFailureWatcher: timed out waiting for pod and event caches to sync")
+	}
+	return nil
+}
+
+func (w *podFailureWatcher) OnDelete(obj any) {}
+
+func (w *podFailureWatcher) OnAdd(maybePod any, isInInitialList bool) {
+	pod, wasPod := maybePod.(*v1.Pod)
+	if !wasPod {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(w.ctx, defaultEventHandlerTimeout)
+	defer cancel()
+	w.cancelJobForPod(ctx, pod, "", "")
+}
+
+func (w *podFailureWatcher) OnUpdate(oldMaybePod, newMaybePod any) {
+	oldPod, oldWasPod := oldMaybePod.(*v1.Pod)
+	newPod, newWasPod := newMaybePod.(*v1.Pod)
+
+	// This nonsense statement is only necessary because the types are too loose.
+	// Most likely both old and new are going to be Pods.
+	ctx, cancel := context.WithTimeout(w.ctx, defaultEventHandlerTimeout)
+	defer cancel()
+	if newWasPod {
+		w.cancelJobForPod(ctx, newPod, "", "")
+	} else if oldWasPod {
+		w.cancelJobForPod(ctx, oldPod, "", "")
+	}
+}
+
+// podEventHandler watches corev1.Event objects involving Pods, so that
+// unrecoverable conditions that only ever surface as an event (rather than
+// a container status, e.g. some container-create failures) still result in
+// the job being cancelled.
+type podEventHandler struct {
+	watcher *podFailureWatcher
+	pods    listersv1.PodLister
+}
+
+func (h *podEventHandler) OnDelete(obj any) {}
+
+func (h *podEventHandler) OnAdd(maybeEvent any, isInInitialList bool) {
+	h.handle(maybeEvent)
+}
+
+func (h *podEventHandler) OnUpdate(_, maybeEvent any) {
+	h.handle(maybeEvent)
+}
+
+func (h *podEventHandler) handle(maybeEvent any) {
+	event, wasEvent := maybeEvent.(*v1.Event)
+	if !wasEvent || event.InvolvedObject.Kind != "Pod" || !isUnrecoverableEventReason(event.Reason, event.Message) {
+		return
+	}
+
+	// Events about a single container name it in FieldPath, e.g.
+	// "spec.containers{name}". Sidecar failures are ignored here just as they
+	// are for container statuses (see isSystemContainer).
+	if _, rest, found := strings.Cut(event.InvolvedObject.FieldPath, "{"); found {
+		if !isSystemContainer(&v1.ContainerStatus{Name: strings.TrimSuffix(rest, "}")}) {
+			return
+		}
+	}
+
+	pod, err := h.pods.Pods(event.InvolvedObject.Namespace).Get(event.InvolvedObject.Name)
+	if err != nil {
+		// Pod may already be gone by the time we process the event.
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(h.watcher.ctx, defaultEventHandlerTimeout)
+	defer cancel()
+	h.watcher.cancelJobForPod(ctx, pod, event.Reason, event.Message)
+}
+
+// unrecoverableEventMessageSubstrings are substrings of a "Failed" or
+// "InspectFailed" event's Message that name one of the same unrecoverable
+// conditions unrecoverableWaitingReasons covers. Kubelet uses those two
+// reasons for all sorts of failures, most of them transient and
+// self-healing (mount retries, registry hiccups, probe-driven restarts), so
+// they're only unrecoverable when the message says so.
+var unrecoverableEventMessageSubstrings = []string{
+	"CreateContainerConfigError",
+	"CreateContainerError",
+	"ErrImagePull",
+	"InvalidImageName",
+}
+
+// isUnrecoverableEventReason reports whether a Kubernetes event recorded
+// against a Pod indicates an unrecoverable condition, mirroring
+// unrecoverableWaitingReasons above. message is only consulted for the
+// generic "Failed"/"InspectFailed" reasons, which cover far more than
+// unrecoverable failures on their own.
+func isUnrecoverableEventReason(reason, message string) bool {
+	switch reason {
+	case "FailedCreatePodContainer", "FailedCreateContainer":
+		return true
+	case "Failed", "InspectFailed":
+		for _, substr := range unrecoverableEventMessageSubstrings {
+			if strings.Contains(message, substr) {
+				return true
+			}
+		}
+		return false
+	default:
+		return slices.Contains(unrecoverableWaitingReasons, reason)
+	}
+}
+
+// cancelJobForPod inspects pod for an unrecoverable container state and, if
+// found, cancels the corresponding Buildkite job. eventReason and
+// eventMessage, if non-empty, come from a Kubernetes event that triggered
+// this check (already validated as unrecoverable by isUnrecoverableEventReason):
+// eventMessage is appended as extra context when a container status also
+// matched, and eventReason is used as the cancellation reason outright when
+// no container status matches at all — some of the conditions we watch for
+// (e.g. FailedCreatePodContainer) only ever surface as an event, never as a
+// container Waiting.Reason.
+func (w *podFailureWatcher) cancelJobForPod(ctx context.Context, pod *v1.Pod, eventReason, eventMessage string) {
+	log := LoggerFromContext(ctx, w.logger).With(zap.String("namespace", pod.Namespace), zap.String("podName", pod.Name))
+	log.Debug("Checking pod for unrecoverable failure")
+
+	if pod.Status.StartTime == nil {
+		// Status could be unpopulated, or it hasn't started yet.
+		return
+	}
+	startedAt := pod.Status.StartTime.Time
+	if startedAt.IsZero() || time.Since(startedAt) < w.gracePeriod {
+		// Not started yet, or started recently
+		return
+	}
+
+	rawJobUUID, exists := pod.GetLabels()[config.UUIDLabel]
+	if !exists {
+		log.Info("Job UUID label not present. Skipping.")
+		return
+	}
+
+	jobUUID, err := uuid.Parse(rawJobUUID)
+	if err != nil {
+		log.Warn("Job UUID label was not a UUID!", zap.String("jobUUID", rawJobUUID))
+		return
+	}
+
+	log = log.With(zap.String("jobUUID", jobUUID.String()))
+
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		reason, shouldCancel := unrecoverableReason(&containerStatus)
+		if !shouldCancel {
+			continue
+		}
+		if !isSystemContainer(&containerStatus) {
+			log.Info("Ignoring sidecar container during pod failure watch.", zap.String("name", containerStatus.Name))
+			continue
+		}
+		if eventMessage != "" {
+			reason = fmt.Sprintf("%s: %s", reason, eventMessage)
+		}
+
+		log.Info("Job has an unrecoverable container failure. Cancelling.", zap.String("reason", reason))
+		w.cancelJob(ctx, log, jobUUID, pod.GetName(), reason)
+		return
+	}
+
+	// No container waiting state matched, which is expected for conditions
+	// that only ever surface as a pod event (see isUnrecoverableEventReason).
+	if eventReason == "" {
+		return
+	}
+	reason := eventReason
+	if eventMessage != "" {
+		reason = fmt.Sprintf("%s: %s", eventReason, eventMessage)
+	}
+	log.Info("Job has an unrecoverable pod event. Cancelling.", zap.String("reason", reason))
+	w.cancelJob(ctx, log, jobUUID, pod.GetName(), reason)
+}
+
+// cancelJob cancels the Buildkite command job with the given UUID, encoding
+// podName and reason into the mutation's clientMutationId so they show up
+// in the Buildkite UI instead of a silent cancel.
+func (w *podFailureWatcher) cancelJob(ctx context.Context, log *zap.Logger, jobUUID uuid.UUID, podName, reason string) {
+	cancelCommandJob(ctx, w.gql, log, jobUUID, fmt.Sprintf("%s: %s", podName, reason))
+}
+
+// unrecoverableReason reports whether containerStatus is in a state that
+// will never resolve on its own, and if so, a short human-readable reason.
+func unrecoverableReason(containerStatus *v1.ContainerStatus) (string, bool) {
+	waiting := containerStatus.State.Waiting
+	if waiting == nil {
+		return "", false
+	}
+	if slices.Contains(unrecoverableWaitingReasons, waiting.Reason) {
+		return waiting.Reason, true
+	}
+	if waiting.Reason == "CrashLoopBackOff" && containerStatus.RestartCount >= crashLoopBackOffThreshold {
+		return fmt.Sprintf("CrashLoopBackOff (%d restarts)", containerStatus.RestartCount), true
+	}
+	return "", false
+}
+
+// All container-\d containers will have the agent installed as their PID 1.
+// Therefore, their lifecycle is well monitored in our backend, allowing us to terminate them if they fail to start.
+//
+// However, sidecar containers are completely unmonitored.
+// We avoid terminating jobs due to sidecar container failures
+// to prevent customer confusion.
+//
+// Most importantly, the CI can still pass (in theory) even if sidecars fail.
+//
+// (The name "system container" is subject to more debate.)
+func isSystemContainer(containerStatus *v1.ContainerStatus) bool {
+	name := containerStatus.Name
+	if slices.Contains([]string{AgentContainerName, CopyAgentContainerName, CheckoutContainerName}, name) {
+		return true
+	}
+	// This will arguably cause some false positives, but:
+	//   1. The change is low.
+	//   2. we plan replace this soon.
+	matched, _ := regexp.MatchString(`container-\d+`, name)
+	return matched
+}
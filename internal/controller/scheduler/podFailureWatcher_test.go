@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestUnrecoverableReason(t *testing.T) {
+	cases := []struct {
+		name          string
+		status        v1.ContainerStatus
+		wantCancel    bool
+		wantReasonHas string
+	}{
+		{
+			name:       "running",
+			status:     v1.ContainerStatus{State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}},
+			wantCancel: false,
+		},
+		{
+			name:          "ImagePullBackOff",
+			status:        v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}},
+			wantCancel:    true,
+			wantReasonHas: "ImagePullBackOff",
+		},
+		{
+			name:          "CreateContainerConfigError",
+			status:        v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CreateContainerConfigError"}}},
+			wantCancel:    true,
+			wantReasonHas: "CreateContainerConfigError",
+		},
+		{
+			name: "CrashLoopBackOff below threshold",
+			status: v1.ContainerStatus{
+				State:        v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				RestartCount: crashLoopBackOffThreshold - 1,
+			},
+			wantCancel: false,
+		},
+		{
+			name: "CrashLoopBackOff at threshold",
+			status: v1.ContainerStatus{
+				State:        v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				RestartCount: crashLoopBackOffThreshold,
+			},
+			wantCancel:    true,
+			wantReasonHas: "CrashLoopBackOff",
+		},
+		{
+			name:       "ContainerCreating (recoverable waiting state)",
+			status:     v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}},
+			wantCancel: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reason, cancel := unrecoverableReason(&tc.status)
+			if cancel != tc.wantCancel {
+				t.Fatalf("unrecoverableReason() cancel = %v, want %v", cancel, tc.wantCancel)
+			}
+			if tc.wantCancel && tc.wantReasonHas != "" {
+				if !strings.Contains(reason, tc.wantReasonHas) {
+					t.Fatalf("unrecoverableReason() reason = %q, want one containing %q", reason, tc.wantReasonHas)
+				}
+			}
+		})
+	}
+}
+
+func TestIsUnrecoverableEventReason(t *testing.T) {
+	cases := []struct {
+		name    string
+		reason  string
+		message string
+		want    bool
+	}{
+		{name: "ErrImagePull reason", reason: "ErrImagePull", want: true},
+		{name: "ImagePullBackOff reason", reason: "ImagePullBackOff", want: true},
+		{name: "FailedCreatePodContainer", reason: "FailedCreatePodContainer", want: true},
+		{name: "FailedCreateContainer", reason: "FailedCreateContainer", want: true},
+		{
+			name:    "Failed with unrecoverable message",
+			reason:  "Failed",
+			message: `Error: ErrImagePull: rpc error: code = Unknown desc = pull access denied`,
+			want:    true,
+		},
+		{
+			name:    "InspectFailed with unrecoverable message",
+			reason:  "InspectFailed",
+			message: "Failed to inspect image: CreateContainerError: container runtime refused to create container",
+			want:    true,
+		},
+		{
+			name:    "Failed with transient message",
+			reason:  "Failed",
+			message: "Failed to pull image: rpc error: code = DeadlineExceeded",
+			want:    false,
+		},
+		{
+			name:    "InspectFailed with transient message",
+			reason:  "InspectFailed",
+			message: "rpc error: code = Unavailable desc = connection refused",
+			want:    false,
+		},
+		{name: "Scheduled", reason: "Scheduled", want: false},
+		{name: "Pulled", reason: "Pulled", want: false},
+		{name: "Started", reason: "Started", want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isUnrecoverableEventReason(tc.reason, tc.message); got != tc.want {
+				t.Errorf("isUnrecoverableEventReason(%q, %q) = %v, want %v", tc.reason, tc.message, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Khan/genqlient/graphql"
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// jobNameLabel is set by the Kubernetes job controller on every pod it
+// creates, naming the owning batchv1.Job.
+const jobNameLabel = "job-name"
+
+// podReaper watches Pods and, once the Buildkite command job behind a pod
+// has been confirmed Canceled/Canceling, deletes the parent batchv1.Job.
+// Without this, a cancelled job's pod can sit around in whatever state it
+// failed in (e.g. ImagePullBackOff) until the Kubernetes job controller
+// notices on its own, wasting scheduler slots and producing repeated
+// informer events for the other watchers in this package.
+type podReaper struct {
+	logger *zap.Logger
+	k8s    kubernetes.Interface
+	gql    graphql.Client
+
+	// ctx is the root context passed to RegisterInformer. Event handlers
+	// derive their per-event context from it (with a timeout) rather than
+	// using context.Background(), so that shutdown cancellation reaches
+	// in-flight GraphQL/Kubernetes calls and any logger attached to ctx is
+	// picked up via LoggerFromContext.
+	ctx context.Context
+
+	// gracePeriod is passed to the Job deletion as GracePeriodSeconds.
+	gracePeriod time.Duration
+}
+
+// NewPodReaper creates a podReaper configured from cfg.
+func NewPodReaper(
+	logger *zap.Logger,
+	k8s kubernetes.Interface,
+	cfg *config.Config,
+) *podReaper {
+	return &podReaper{
+		logger:      logger,
+		k8s:         k8s,
+		gql:         api.NewClient(cfg.BuildkiteToken),
+		gracePeriod: cfg.PodTerminationGracePeriod,
+		ctx:         context.Background(),
+	}
+}
+
+// Creates a Pods informer and registers the handler on it.
+func (r *podReaper) RegisterInformer(
+	ctx context.Context,
+	factory informers.SharedInformerFactory,
+) error {
+	r.ctx = ctx
+
+	informer := factory.Core().V1().Pods().Informer()
+	if _, err := informer.AddEventHandler(r); err != nil {
+		return err
+	}
+	go factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("podReaper: timed out waiting for pod cache to sync")
+	}
+	return nil
+}
+
+func (r *podReaper) OnDelete(obj any) {}
+
+func (r *podReaper) OnAdd(maybePod any, isInInitialList bool) {
+	if pod, ok := maybePod.(*v1.Pod); ok {
+		ctx, cancel := context.WithTimeout(r.ctx, defaultEventHandlerTimeout)
+		defer cancel()
+		r.reapIfCancelled(ctx, pod)
+	}
+}
+
+func (r *podReaper) OnUpdate(_, maybePod any) {
+	if pod, ok := maybePod.(*v1.Pod); ok {
+		ctx, cancel := context.WithTimeout(r.ctx, defaultEventHandlerTimeout)
+		defer cancel()
+		r.reapIfCancelled(ctx, pod)
+	}
+}
+
+// reapIfCancelled deletes pod's parent Job if the Buildkite command job it
+// was running has been confirmed Canceled/Canceling.
+func (r *podReaper) reapIfCancelled(ctx context.Context, pod *v1.Pod) {
+	if !podLooksStuckOrDone(pod) {
+		// Cheap local check: the overwhelming majority of pod add/update
+		// events are ordinary pending/running pods on their way to a
+		// normal completion, and don't need a GraphQL round trip to tell
+		// us that. Only pods that already look stuck or finished are
+		// candidates for being the tail end of a cancelled job.
+		return
+	}
+
+	log := LoggerFromContext(ctx, r.logger).With(zap.String("namespace", pod.Namespace), zap.String("podName", pod.Name))
+
+	rawJobUUID, exists := pod.GetLabels()[config.UUIDLabel]
+	if !exists {
+		return
+	}
+	jobUUID, err := uuid.Parse(rawJobUUID)
+	if err != nil {
+		return
+	}
+	jobName, exists := pod.GetLabels()[jobNameLabel]
+	if !exists {
+		return
+	}
+	log = log.With(zap.String("jobUUID", jobUUID.String()), zap.String("jobName", jobName))
+
+	resp, err := api.GetCommandJob(ctx, r.gql, jobUUID.String())
+	if err != nil {
+		log.Warn("Failed to query command job", zap.Error(err))
+		return
+	}
+
+	job, isCommandJob := resp.GetJob().(*api.GetCommandJobJobJobTypeCommand)
+	if !isCommandJob {
+		return
+	}
+	if job.GetState() != api.JobStatesCanceled && job.GetState() != api.JobStatesCanceling {
+		// Not cancelled; leave it for the Kubernetes job controller to
+		// reap in the ordinary course of the job finishing.
+		return
+	}
+
+	gracePeriodSeconds := int64(r.gracePeriod.Seconds())
+	deleteOptions := metav1.NewDeleteOptions(gracePeriodSeconds)
+	propagationPolicy := metav1.DeletePropagationBackground
+	deleteOptions.PropagationPolicy = &propagationPolicy
+
+	if err := r.k8s.BatchV1().Jobs(pod.Namespace).Delete(ctx, jobName, *deleteOptions); err != nil {
+		log.Warn("Failed to reap Kubernetes job", zap.Error(err))
+		return
+	}
+
+	log.Info("Reaped Kubernetes job for cancelled command job", zap.Int64("gracePeriodSeconds", gracePeriodSeconds))
+}
+
+// podLooksStuckOrDone reports whether pod is in a state worth spending a
+// GraphQL call on to check for cancellation: it has finished, its phase is
+// Unknown, it is being deleted, or it has a container in the same unrecoverable state the pod
+// failure watcher cancels jobs for. Ordinary pending/running pods return
+// false so we don't query Buildkite for every routine pod update.
+func podLooksStuckOrDone(pod *v1.Pod) bool {
+	switch pod.Status.Phase {
+	case v1.PodFailed, v1.PodSucceeded, v1.PodUnknown:
+		return true
+	}
+	if pod.DeletionTimestamp != nil {
+		return true
+	}
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if _, unrecoverable := unrecoverableReason(&containerStatus); unrecoverable {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodLooksStuckOrDone(t *testing.T) {
+	cases := []struct {
+		name string
+		pod  v1.Pod
+		want bool
+	}{
+		{
+			name: "pending",
+			pod:  v1.Pod{Status: v1.PodStatus{Phase: v1.PodPending}},
+			want: false,
+		},
+		{
+			name: "running healthily",
+			pod: v1.Pod{Status: v1.PodStatus{
+				Phase: v1.PodRunning,
+				ContainerStatuses: []v1.ContainerStatus{
+					{State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}},
+				},
+			}},
+			want: false,
+		},
+		{
+			name: "failed",
+			pod:  v1.Pod{Status: v1.PodStatus{Phase: v1.PodFailed}},
+			want: true,
+		},
+		{
+			name: "succeeded",
+			pod:  v1.Pod{Status: v1.PodStatus{Phase: v1.PodSucceeded}},
+			want: true,
+		},
+		{
+			name: "being deleted",
+			pod: v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &metav1.Time{Time: time.Now()}},
+				Status:     v1.PodStatus{Phase: v1.PodRunning},
+			},
+			want: true,
+		},
+		{
+			name: "container in ImagePullBackOff",
+			pod: v1.Pod{Status: v1.PodStatus{
+				Phase: v1.PodPending,
+				ContainerStatuses: []v1.ContainerStatus{
+					{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}},
+				},
+			}},
+			want: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := podLooksStuckOrDone(&tc.pod); got != tc.want {
+				t.Errorf("podLooksStuckOrDone() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}